@@ -5,9 +5,9 @@ package activitytracker
 import (
 	"encoding/binary"
 	"flag"
-	"io"
 	"os"
 	"strings"
+	"sync"
 	"time"
 	"unicode/utf8"
 
@@ -30,25 +30,69 @@ type ActivityTracker struct {
 
 	failedInserts       *prometheus.CounterVec
 	freeActivityEntries prometheus.GaugeFunc
+	activitiesTotal     *prometheus.CounterVec
+	activityDuration    *prometheus.HistogramVec
+	oldestActivity      prometheus.GaugeFunc
+
+	categorize func(activity string) string
+	// slotCategory remembers the category each occupied slot was inserted with, so that
+	// Delete can observe activityDuration with the same label without having to re-derive
+	// it (and without growing the on-disk entry format).
+	slotCategory []string
+
+	// timestampsMu guards slotTimestamp, a mirror of each occupied slot's Insert
+	// timestamp kept purely in memory. oldestActivitySeconds reads this instead of
+	// the live mmap buffer: Delete zeroes a slot's bytes with a non-atomic copy, so
+	// a scrape landing mid-zero could otherwise read a torn, nonsense timestamp.
+	timestampsMu  sync.Mutex
+	slotTimestamp []time.Time
+
+	subscribersMu    sync.Mutex
+	subscribers      map[int]chan Event
+	nextSubscriberID int
+
+	overflow *overflowStore
 }
 
 const (
 	entrySize int = 1024
 
-	reasonFull          = "tracker_full"
-	reasonEmptyActivity = "empty_activity"
+	reasonFull                = "tracker_full"
+	reasonEmptyActivity       = "empty_activity"
+	reasonOverflowWriteFailed = "overflow_write_failed"
+
+	// Per-slot header: timestamp, then the fields needed to find an activity's
+	// overflow tail (if any) in the companion overflow file. totalLength is the
+	// full activity length, so readers know exactly how many of the inline bytes
+	// are real and how many (if any) of the tail were spilled to overflow.
+	totalLengthSize    = 4 // uint32
+	overflowSegSize    = 4 // uint32
+	overflowOffSize    = 8 // uint64
+	headerSize         = timestampLength + totalLengthSize + overflowSegSize + overflowOffSize
+	inlineActivitySize = entrySize - headerSize
+
+	defaultOverflowSegmentBytes int64 = 16 << 20 // 16MiB
 )
 
 var emptyEntry = make([]byte, entrySize)
 
 type Config struct {
-	Filepath   string `yaml:"filepath"`
-	MaxEntries int    `yaml:"max_entries" category:"advanced"`
+	Filepath             string `yaml:"filepath"`
+	MaxEntries           int    `yaml:"max_entries" category:"advanced"`
+	OverflowSegmentBytes int64  `yaml:"overflow_segment_bytes" category:"advanced"`
+
+	// Categorize derives a low-cardinality category label from an activity's
+	// description, used for the activity_tracker_activities_total and
+	// activity_tracker_duration_seconds metrics. Defaults to the activity's first
+	// whitespace-delimited token when unset. Not configurable via flags: set it in
+	// code, e.g. so the query-frontend can group by request kind.
+	Categorize func(activity string) string `yaml:"-"`
 }
 
 func (c *Config) RegisterFlags(f *flag.FlagSet) {
 	f.StringVar(&c.Filepath, "activity-tracker.filepath", "./metrics-activity.log", "File where ongoing activities are stored. If empty, activity tracking is disabled.")
 	f.IntVar(&c.MaxEntries, "activity-tracker.max-entries", 1024, "Max number of concurrent activities that can be tracked. Used to size the file in advance. Additional activities are ignored.")
+	f.Int64Var(&c.OverflowSegmentBytes, "activity-tracker.overflow-segment-bytes", defaultOverflowSegmentBytes, "Maximum size of a single activity overflow segment, used to store the parts of activities that don't fit inline in the fixed-size slot file, before rotating to a new segment.")
 }
 
 func NewActivityTracker(cfg Config, reg prometheus.Registerer) (*ActivityTracker, error) {
@@ -62,20 +106,54 @@ func NewActivityTracker(cfg Config, reg prometheus.Registerer) (*ActivityTracker
 		return nil, err
 	}
 
+	categorize := cfg.Categorize
+	if categorize == nil {
+		categorize = defaultCategorize
+	}
+
+	overflowThreshold := cfg.OverflowSegmentBytes
+	if overflowThreshold <= 0 {
+		overflowThreshold = defaultOverflowSegmentBytes
+	}
+	overflow, err := newOverflowStore(cfg.Filepath, overflowThreshold)
+	if err != nil {
+		_ = fileAsBytes.Unmap()
+		_ = file.Close()
+		return nil, err
+	}
+
 	tracker := &ActivityTracker{
 		file:           file,
 		fileBytes:      fileAsBytes,
 		freeIndexQueue: make(chan int, cfg.MaxEntries),
 		maxEntries:     cfg.MaxEntries,
+		subscribers:    map[int]chan Event{},
+
+		categorize:    categorize,
+		slotCategory:  make([]string, cfg.MaxEntries),
+		slotTimestamp: make([]time.Time, cfg.MaxEntries),
+		overflow:      overflow,
 
 		failedInserts: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
 			Name: "activity_tracker_failed_total",
 			Help: "How many times has activity tracker failed to insert new activity.",
 		}, []string{"reason"}),
+
+		activitiesTotal: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "activity_tracker_activities_total",
+			Help: "Total number of activities inserted into the tracker, by category.",
+		}, []string{"category"}),
+
+		activityDuration: promauto.With(reg).NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "activity_tracker_duration_seconds",
+			Help:    "Duration of tracked activities, from Insert to Delete, by category.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"category"}),
 	}
 
 	tracker.failedInserts.WithLabelValues(reasonFull)
 	tracker.failedInserts.WithLabelValues(reasonEmptyActivity)
+	tracker.failedInserts.WithLabelValues(reasonOverflowWriteFailed)
 
 	tracker.freeActivityEntries = promauto.With(reg).NewGaugeFunc(prometheus.GaugeOpts{
 		Name: "activity_tracker_free_slots",
@@ -84,6 +162,11 @@ func NewActivityTracker(cfg Config, reg prometheus.Registerer) (*ActivityTracker
 		return float64(len(tracker.freeIndexQueue))
 	})
 
+	tracker.oldestActivity = promauto.With(reg).NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "activity_tracker_oldest_activity_seconds",
+		Help: "Age, in seconds, of the oldest activity currently tracked. 0 if nothing is tracked.",
+	}, tracker.oldestActivitySeconds)
+
 	for i := 0; i < cfg.MaxEntries; i++ {
 		tracker.freeIndexQueue <- i
 	}
@@ -91,6 +174,16 @@ func NewActivityTracker(cfg Config, reg prometheus.Registerer) (*ActivityTracker
 	return tracker, nil
 }
 
+// defaultCategorize derives a category by taking the activity's text up to the first
+// space, tab or newline, e.g. "query range from=... to=..." categorizes as "query".
+// An activity that starts with one of those characters categorizes as "".
+func defaultCategorize(activity string) string {
+	if i := strings.IndexAny(activity, " \t\n"); i >= 0 {
+		return activity[:i]
+	}
+	return activity
+}
+
 // Timestamp is encoded as uint64 value returned by time.UnixNano.
 const timestampLength = 8
 
@@ -98,11 +191,24 @@ const timestampLength = 8
 // is full, activityGenerator is not called. Value returned by Insert is to be used with Delete method
 // after activity has finished.
 //
-// String returned by activityGenerator should be human-readable description of activity.
-// If it is bigger than max entry size, it will be trimmed on latest utf-8 rune start before the limit.
+// String returned by activityGenerator should be human-readable description of activity. Activities
+// longer than fit inline in the slot file have their tail spilled to the overflow file, so they are
+// not truncated; only if writing the overflow fails is the activity trimmed, on the latest utf-8 rune
+// start before the limit.
 //
 // Note that timestamp of Insert call is stored automatically with the tracked activity.
 func (t *ActivityTracker) Insert(activityGenerator func() string) (activityIndex int) {
+	return t.insert("", activityGenerator)
+}
+
+// InsertWithCategory behaves like Insert, but uses category for the
+// activity_tracker_activities_total and activity_tracker_duration_seconds metrics
+// instead of deriving one from the generated activity via Config.Categorize.
+func (t *ActivityTracker) InsertWithCategory(category string, activityGenerator func() string) (activityIndex int) {
+	return t.insert(category, activityGenerator)
+}
+
+func (t *ActivityTracker) insert(category string, activityGenerator func() string) (activityIndex int) {
 	if t == nil {
 		return -1
 	}
@@ -117,11 +223,42 @@ func (t *ActivityTracker) Insert(activityGenerator func() string) (activityIndex
 		}
 
 		ix := i * entrySize
-		binary.BigEndian.PutUint64(t.fileBytes[ix:], uint64(time.Now().UnixNano()))
+		now := time.Now()
+
+		totalLen := len(activity)
+		inline := activity
+		var ref overflowRef
+
+		if totalLen > inlineActivitySize {
+			putRef, putErr := t.overflow.put([]byte(activity[inlineActivitySize:]))
+			if putErr != nil {
+				t.failedInserts.WithLabelValues(reasonOverflowWriteFailed).Inc()
+				activity = trimEntryToSize(activity, inlineActivitySize)
+				totalLen = len(activity)
+				inline = activity
+			} else {
+				ref = putRef
+				inline = activity[:inlineActivitySize]
+			}
+		}
+
+		binary.BigEndian.PutUint64(t.fileBytes[ix:], uint64(now.UnixNano()))
+		binary.BigEndian.PutUint32(t.fileBytes[ix+timestampLength:], uint32(totalLen))
+		binary.BigEndian.PutUint32(t.fileBytes[ix+timestampLength+totalLengthSize:], ref.segment)
+		binary.BigEndian.PutUint64(t.fileBytes[ix+timestampLength+totalLengthSize+overflowSegSize:], uint64(ref.offset))
+		copy(t.fileBytes[ix+headerSize:], inline)
 
-		activity = trimEntryToSize(activity, entrySize-timestampLength)
+		if category == "" {
+			category = t.categorize(activity)
+		}
+		t.slotCategory[i] = category
+		t.activitiesTotal.WithLabelValues(category).Inc()
 
-		copy(t.fileBytes[ix+timestampLength:], activity)
+		t.timestampsMu.Lock()
+		t.slotTimestamp[i] = now
+		t.timestampsMu.Unlock()
+
+		t.publish(Event{Kind: Inserted, Index: i, Entry: Entry{Timestamp: now, Activity: activity}})
 		return i
 	default:
 		t.failedInserts.WithLabelValues(reasonFull).Inc()
@@ -141,8 +278,54 @@ func (t *ActivityTracker) Delete(activityIndex int) {
 		return
 	}
 
+	deleted, ref := t.decodeSlot(t.fileBytes[activityIndex*entrySize : (activityIndex+1)*entrySize])
+
+	category := t.slotCategory[activityIndex]
+	if category == "" {
+		category = t.categorize(deleted.Activity)
+	}
+	t.slotCategory[activityIndex] = ""
+
+	t.timestampsMu.Lock()
+	t.slotTimestamp[activityIndex] = time.Time{}
+	t.timestampsMu.Unlock()
+
 	copy(t.fileBytes[activityIndex*entrySize:], emptyEntry)
 	t.freeIndexQueue <- activityIndex
+	t.overflow.release(ref)
+
+	if !deleted.Timestamp.IsZero() {
+		t.activityDuration.WithLabelValues(category).Observe(time.Since(deleted.Timestamp).Seconds())
+	}
+
+	t.publish(Event{Kind: Deleted, Index: activityIndex, Entry: deleted})
+}
+
+// oldestActivitySeconds returns the age, in seconds, of the oldest activity
+// currently tracked, or 0 if nothing is tracked. It is computed lazily, on each
+// Prometheus scrape, from slotTimestamp rather than maintained incrementally.
+//
+// It deliberately does not scan the live mmap buffer: Delete zeroes a slot's bytes
+// with a non-atomic copy, so a scrape racing a Delete could read a half-zeroed
+// timestamp and report a nonsense age.
+func (t *ActivityTracker) oldestActivitySeconds() float64 {
+	var oldest time.Time
+
+	t.timestampsMu.Lock()
+	for _, ts := range t.slotTimestamp {
+		if ts.IsZero() {
+			continue
+		}
+		if oldest.IsZero() || ts.Before(oldest) {
+			oldest = ts
+		}
+	}
+	t.timestampsMu.Unlock()
+
+	if oldest.IsZero() {
+		return 0
+	}
+	return time.Since(oldest).Seconds()
 }
 
 // Close closes activity tracker. Calling other methods after Close() will likely panic. Don't do that.
@@ -153,8 +336,9 @@ func (t *ActivityTracker) Close() error {
 
 	err1 := t.fileBytes.Unmap()
 	err2 := t.file.Close()
+	err3 := t.overflow.Close()
 
-	return multierror.New(err1, err2).Err()
+	return multierror.New(err1, err2, err3).Err()
 }
 
 // Trim entry to given size limit, respecting UTF-8 rune boundaries.
@@ -171,6 +355,51 @@ func trimEntryToSize(entry string, size int) string {
 	return entry[:l]
 }
 
+// decodeSlotHeader decodes the header and inline prefix of a single raw entrySize-sized
+// slot. buf must be entrySize bytes long. empty is true if the slot holds no activity,
+// in which case the other return values are meaningless. ref describes the activity's
+// overflow tail, if any (ref.length is 0 when the activity fit entirely inline).
+func decodeSlotHeader(buf []byte) (ts time.Time, totalLen int, ref overflowRef, inline string, empty bool) {
+	if string(buf) == string(emptyEntry) {
+		return time.Time{}, 0, overflowRef{}, "", true
+	}
+
+	ts = time.Unix(0, int64(binary.BigEndian.Uint64(buf)))
+	totalLen = int(binary.BigEndian.Uint32(buf[timestampLength:]))
+	seg := binary.BigEndian.Uint32(buf[timestampLength+totalLengthSize:])
+	off := int64(binary.BigEndian.Uint64(buf[timestampLength+totalLengthSize+overflowSegSize:]))
+
+	inlineLen := totalLen
+	if inlineLen > inlineActivitySize {
+		inlineLen = inlineActivitySize
+	}
+	inline = string(buf[headerSize : headerSize+inlineLen])
+
+	overflowLen := totalLen - inlineActivitySize
+	if overflowLen < 0 {
+		overflowLen = 0
+	}
+
+	return ts, totalLen, overflowRef{segment: seg, offset: off, length: overflowLen}, inline, false
+}
+
+// decodeSlot decodes a slot into a fully reconstructed Entry, reading the activity's
+// overflow tail (if any) from t.overflow. The returned overflowRef should be passed to
+// t.overflow.release once the slot is freed.
+func (t *ActivityTracker) decodeSlot(buf []byte) (Entry, overflowRef) {
+	ts, _, ref, inline, empty := decodeSlotHeader(buf)
+	if empty {
+		return Entry{}, overflowRef{}
+	}
+
+	activity := inline
+	if tail, err := t.overflow.get(ref); err == nil && len(tail) > 0 {
+		activity = inline + string(tail)
+	}
+
+	return Entry{Timestamp: ts, Activity: activity}, ref
+}
+
 func getMappedFile(filename string, filesize int) (*os.File, mmap.MMap, error) {
 	file, err := os.OpenFile(filename, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0666)
 	if err != nil {
@@ -202,45 +431,7 @@ func getMappedFile(filename string, filesize int) (*os.File, mmap.MMap, error) {
 type Entry struct {
 	Timestamp time.Time
 	Activity  string
-}
-
-// LoadUnfinishedEntries loads and returns list of unfinished activities in the activity file.
-func LoadUnfinishedEntries(file string) ([]Entry, error) {
-	fd, err := os.Open(file)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return nil, nil
-		}
-		return nil, err
-	}
-
-	defer func() { _ = fd.Close() }()
-
-	var results []Entry
-
-	buf := make([]byte, entrySize)
-	var n int
-	for n, err = io.ReadFull(fd, buf); err == nil; _, err = io.ReadFull(fd, buf) {
-		s := string(buf[:n])
-		if s == string(emptyEntry) {
-			continue
-		}
-
-		var ts = time.Unix(0, int64(binary.BigEndian.Uint64(buf)))
-
-		s = s[timestampLength:]
-		s = strings.ReplaceAll(s, "\x00", "")
-
-		results = append(results, Entry{
-			Timestamp: ts,
-			Activity:  s,
-		})
-	}
-
-	// io.ReadFull returns io.EOF if it reads no more bytes. This is good.
-	if errors.Is(err, io.EOF) {
-		err = nil
-	}
-
-	return results, err
+	// Index is the activity's slot index in the file. Only populated by
+	// LoadUnfinishedEntries; zero elsewhere.
+	Index int
 }
@@ -0,0 +1,293 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package activitytracker
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+)
+
+// EventKind describes what happened to an activity slot.
+type EventKind int
+
+const (
+	Inserted EventKind = iota
+	Deleted
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case Inserted:
+		return "inserted"
+	case Deleted:
+		return "deleted"
+	default:
+		return "unknown"
+	}
+}
+
+// Event describes a single change observed on an activity tracker, either in-process
+// (via Follow) or by tailing its file from another process (via FollowFile).
+type Event struct {
+	Kind  EventKind
+	Index int
+	Entry Entry
+}
+
+// followChannelBuffer bounds how far a subscriber can fall behind before new
+// events are dropped for it; the unread events already buffered are kept.
+const followChannelBuffer = 256
+
+// Follow returns a channel that emits an Event every time Insert or Delete is called
+// on this tracker, until ctx is done. The channel is closed when ctx is done.
+//
+// opts is accepted for parity with FollowFile, but both of its fields are no-ops
+// here: Since only matters for replaying entries from a file written before the
+// caller started watching it, and an in-process Follow has no such backlog to
+// replay (a caller that wants the currently-running activities already has the
+// tracker and can list them directly); PollInterval only matters for the polling
+// fallback FollowFile uses when fsnotify isn't available, and Follow observes
+// Insert/Delete calls directly rather than polling anything.
+//
+// Follow never blocks Insert or Delete: if a subscriber falls behind, events are
+// dropped rather than slowing down the tracker.
+//
+// A nil activity tracker returns a channel that is closed immediately, consistent
+// with its public API otherwise ignoring all calls.
+func (t *ActivityTracker) Follow(ctx context.Context, opts FollowOptions) (<-chan Event, error) {
+	if t == nil {
+		ch := make(chan Event)
+		close(ch)
+		return ch, nil
+	}
+
+	ch := make(chan Event, followChannelBuffer)
+
+	t.subscribersMu.Lock()
+	id := t.nextSubscriberID
+	t.nextSubscriberID++
+	t.subscribers[id] = ch
+	t.subscribersMu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		t.subscribersMu.Lock()
+		delete(t.subscribers, id)
+		t.subscribersMu.Unlock()
+
+		close(ch)
+	}()
+
+	return ch, nil
+}
+
+// publish fans ev out to all live subscribers, dropping it for any subscriber whose
+// channel is full instead of blocking the caller (Insert or Delete).
+func (t *ActivityTracker) publish(ev Event) {
+	t.subscribersMu.Lock()
+	defer t.subscribersMu.Unlock()
+
+	for _, ch := range t.subscribers {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// FollowOptions configures FollowFile.
+type FollowOptions struct {
+	// Since makes FollowFile replay unfinished entries whose timestamp is after Since
+	// before switching to live tailing. A zero Since replays every unfinished entry.
+	Since time.Time
+
+	// PollInterval bounds how long FollowFile can go without noticing a change,
+	// acting both as the fallback mechanism on platforms without inotify and as a
+	// safety net against missed or coalesced fsnotify events. Defaults to 2s.
+	PollInterval time.Duration
+}
+
+// ParseSince parses the value of a "--since" flag, accepting either a duration
+// (meaning "that long ago", relative to now) or an RFC3339 timestamp.
+func ParseSince(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "--since must be a duration (e.g. \"30s\") or an RFC3339 timestamp")
+	}
+	return t, nil
+}
+
+// FollowFile watches the activity file at path from outside the process that owns it
+// (e.g. a CLI tool pointed at a running ingester's shared volume) and emits an Event
+// for every slot that appears, disappears, or changes, by diffing mmap slot contents
+// on each notification. It prefers fsnotify, falling back to polling on platforms
+// without inotify support, the same strategy used by hpcloud/tail.
+//
+// Unfinished entries already in the file that are newer than opts.Since (all of
+// them, if opts.Since is zero) are replayed as Inserted events before FollowFile
+// switches to live tailing.
+func FollowFile(ctx context.Context, path string, opts FollowOptions) (<-chan Event, error) {
+	pollInterval := opts.PollInterval
+	if pollInterval <= 0 {
+		pollInterval = 2 * time.Second
+	}
+
+	watcher, watchErr := fsnotify.NewWatcher()
+	usePolling := watchErr != nil
+	if !usePolling {
+		if err := watcher.Add(path); err != nil {
+			// The file may not exist yet; fall back to polling (retrying watcher.Add
+			// on every tick below) until it appears.
+			usePolling = true
+		}
+	}
+
+	ch := make(chan Event, followChannelBuffer)
+
+	go func() {
+		defer close(ch)
+		if watcher != nil {
+			defer func() { _ = watcher.Close() }()
+		}
+
+		overflow := newOverflowReader(path)
+		defer func() { _ = overflow.Close() }()
+
+		known := map[int]Entry{}
+
+		if cur, err := scanSlots(path, overflow); err == nil {
+			for ix, entry := range cur {
+				known[ix] = entry
+				if entry.Timestamp.After(opts.Since) {
+					select {
+					case ch <- Event{Kind: Inserted, Index: ix, Entry: entry}:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+		}
+
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+
+		var fsEvents <-chan fsnotify.Event
+		if watcher != nil && !usePolling {
+			fsEvents = watcher.Events
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if usePolling && watcher != nil {
+					// The watch couldn't be established yet (typically because the file
+					// didn't exist at startup); retry it on every tick so we switch from
+					// polling to fsnotify as soon as it can succeed.
+					if err := watcher.Add(path); err == nil {
+						usePolling = false
+						fsEvents = watcher.Events
+					}
+				}
+				known = diffSlots(ch, ctx, path, overflow, known)
+			case _, ok := <-fsEvents:
+				if !ok {
+					return
+				}
+				known = diffSlots(ch, ctx, path, overflow, known)
+			}
+		}
+	}()
+
+	return ch, nil
+}
+
+// diffSlots re-scans path and publishes Inserted/Deleted events for every slot whose
+// contents differ from known, returning the updated snapshot.
+//
+// Known limitation: if a slot index is deleted and reused by a different activity
+// between two scans, diffSlots only sees the index occupied in both and emits a
+// single Inserted for the new content — the old entry's Deleted event is lost. A
+// consumer pairing Inserted/Deleted per index can desync under load; shortening
+// PollInterval reduces but does not eliminate the chance of this happening.
+func diffSlots(ch chan<- Event, ctx context.Context, path string, overflow *overflowReader, known map[int]Entry) map[int]Entry {
+	cur, err := scanSlots(path, overflow)
+	if err != nil {
+		return known
+	}
+
+	for ix, entry := range cur {
+		if prev, ok := known[ix]; !ok || prev != entry {
+			select {
+			case ch <- Event{Kind: Inserted, Index: ix, Entry: entry}:
+			case <-ctx.Done():
+				return cur
+			}
+		}
+	}
+
+	for ix, entry := range known {
+		if _, ok := cur[ix]; !ok {
+			select {
+			case ch <- Event{Kind: Deleted, Index: ix, Entry: entry}:
+			case <-ctx.Done():
+				return cur
+			}
+		}
+	}
+
+	return cur
+}
+
+// scanSlots reads every occupied slot of the activity file at path, stitching in
+// overflow tails via overflow, and returns them keyed by slot index, without holding
+// the file open or mmap'd.
+func scanSlots(path string, overflow *overflowReader) (map[int]Entry, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = fd.Close() }()
+
+	info, err := fd.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[int]Entry{}
+	buf := make([]byte, entrySize)
+
+	for ix := 0; int64(ix)*int64(entrySize) < info.Size(); ix++ {
+		if _, err := fd.ReadAt(buf, int64(ix)*int64(entrySize)); err != nil {
+			break
+		}
+
+		ts, _, ref, inline, empty := decodeSlotHeader(buf)
+		if empty {
+			continue
+		}
+
+		activity := inline
+		if tail, err := overflow.get(ref); err == nil && len(tail) > 0 {
+			activity = inline + string(tail)
+		}
+
+		result[ix] = Entry{Timestamp: ts, Activity: activity}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,123 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package activitytracker
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestTracker(t *testing.T) *ActivityTracker {
+	t.Helper()
+
+	cfg := Config{
+		Filepath:   filepath.Join(t.TempDir(), "activity.log"),
+		MaxEntries: 8,
+	}
+	tracker, err := NewActivityTracker(cfg, nil)
+	if err != nil {
+		t.Fatalf("NewActivityTracker: %v", err)
+	}
+	t.Cleanup(func() { _ = tracker.Close() })
+
+	return tracker
+}
+
+func TestActivityTracker_FollowEmitsInsertedThenDeleted(t *testing.T) {
+	tracker := newTestTracker(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events, err := tracker.Follow(ctx, FollowOptions{})
+	if err != nil {
+		t.Fatalf("Follow: %v", err)
+	}
+
+	ix := tracker.InsertStatic("doing some work")
+	if ix < 0 {
+		t.Fatalf("InsertStatic failed")
+	}
+	tracker.Delete(ix)
+
+	inserted := waitForEvent(t, events)
+	if inserted.Kind != Inserted || inserted.Index != ix || inserted.Entry.Activity != "doing some work" {
+		t.Fatalf("unexpected first event: %+v", inserted)
+	}
+
+	deleted := waitForEvent(t, events)
+	if deleted.Kind != Deleted || deleted.Index != ix {
+		t.Fatalf("unexpected second event: %+v", deleted)
+	}
+}
+
+func TestActivityTracker_FollowNilTrackerReturnsClosedChannel(t *testing.T) {
+	var tracker *ActivityTracker
+
+	events, err := tracker.Follow(context.Background(), FollowOptions{})
+	if err != nil {
+		t.Fatalf("Follow: %v", err)
+	}
+
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Fatalf("expected channel to be closed with no events")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected channel to already be closed")
+	}
+}
+
+func waitForEvent(t *testing.T, ch <-chan Event) Event {
+	t.Helper()
+
+	select {
+	case ev := <-ch:
+		return ev
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for event")
+		return Event{}
+	}
+}
+
+func TestFollowFile_ReplaysUnfinishedEntriesThenLiveTails(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "activity.log")
+
+	cfg := Config{Filepath: path, MaxEntries: 8}
+	tracker, err := NewActivityTracker(cfg, nil)
+	if err != nil {
+		t.Fatalf("NewActivityTracker: %v", err)
+	}
+	defer func() { _ = tracker.Close() }()
+
+	preexisting := tracker.InsertStatic("already running before FollowFile starts")
+	if preexisting < 0 {
+		t.Fatalf("InsertStatic failed")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events, err := FollowFile(ctx, path, FollowOptions{PollInterval: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("FollowFile: %v", err)
+	}
+
+	replayed := waitForEvent(t, events)
+	if replayed.Kind != Inserted || replayed.Entry.Activity != "already running before FollowFile starts" {
+		t.Fatalf("expected replay of the preexisting entry, got %+v", replayed)
+	}
+
+	live := tracker.InsertStatic("started after FollowFile began tailing")
+	if live < 0 {
+		t.Fatalf("InsertStatic failed")
+	}
+
+	liveEvent := waitForEvent(t, events)
+	if liveEvent.Kind != Inserted || liveEvent.Entry.Activity != "started after FollowFile began tailing" {
+		t.Fatalf("expected a live-tailed insert event, got %+v", liveEvent)
+	}
+}
@@ -0,0 +1,156 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package activitytracker
+
+import (
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// SortOrder controls the order in which LoadUnfinishedEntries returns entries.
+type SortOrder int
+
+const (
+	// SortNone leaves entries in on-disk slot order (the default).
+	SortNone SortOrder = iota
+	SortByTimestamp
+	SortByIndex
+)
+
+// LoadOption configures LoadUnfinishedEntries.
+type LoadOption func(*loadOptions)
+
+type loadOptions struct {
+	since      time.Time
+	maxAge     time.Duration
+	hasMaxAge  bool
+	category   string
+	activityRE *regexp.Regexp
+	sort       SortOrder
+	limit      int
+}
+
+// WithSince keeps only entries with a timestamp after t.
+func WithSince(t time.Time) LoadOption {
+	return func(o *loadOptions) { o.since = t }
+}
+
+// WithMaxAge keeps only entries younger than d, relative to now.
+func WithMaxAge(d time.Duration) LoadOption {
+	return func(o *loadOptions) { o.maxAge, o.hasMaxAge = d, true }
+}
+
+// WithCategory keeps only entries whose activity categorizes (via the same rule
+// as Config.Categorize's default, the first whitespace-delimited token) as prefix.
+func WithCategory(prefix string) LoadOption {
+	return func(o *loadOptions) { o.category = prefix }
+}
+
+// WithActivityRegex keeps only entries whose activity matches re.
+func WithActivityRegex(re *regexp.Regexp) LoadOption {
+	return func(o *loadOptions) { o.activityRE = re }
+}
+
+// WithSort orders the returned entries. Defaults to on-disk slot order.
+func WithSort(order SortOrder) LoadOption {
+	return func(o *loadOptions) { o.sort = order }
+}
+
+// WithLimit caps the number of returned entries to n, applied after sorting.
+func WithLimit(n int) LoadOption {
+	return func(o *loadOptions) { o.limit = n }
+}
+
+// LoadUnfinishedEntries loads and returns the list of unfinished activities in the
+// activity file, optionally filtered, sorted and limited by opts. Without opts, it
+// returns every unfinished entry in on-disk slot order, as before.
+func LoadUnfinishedEntries(file string, opts ...LoadOption) ([]Entry, error) {
+	var o loadOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	fd, err := os.Open(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	defer func() { _ = fd.Close() }()
+
+	overflow := newOverflowReader(file)
+	defer func() { _ = overflow.Close() }()
+
+	var results []Entry
+
+	buf := make([]byte, entrySize)
+	for ix := 0; ; ix++ {
+		if _, err = io.ReadFull(fd, buf); err != nil {
+			break
+		}
+
+		ts, _, ref, inline, empty := decodeSlotHeader(buf)
+		if empty {
+			continue
+		}
+
+		activity := inline
+		if tail, tailErr := overflow.get(ref); tailErr == nil && len(tail) > 0 {
+			activity = inline + string(tail)
+		}
+
+		entry := Entry{Timestamp: ts, Activity: activity, Index: ix}
+		if !matches(entry, o) {
+			continue
+		}
+
+		results = append(results, entry)
+	}
+
+	// io.ReadFull returns io.EOF if it reads no more bytes. This is good.
+	if errors.Is(err, io.EOF) {
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch o.sort {
+	case SortByTimestamp:
+		sort.Slice(results, func(i, j int) bool { return results[i].Timestamp.Before(results[j].Timestamp) })
+	case SortByIndex:
+		sort.Slice(results, func(i, j int) bool { return results[i].Index < results[j].Index })
+	}
+
+	// Limit keeps the last n entries of the order above, taking off the front. With
+	// SortByTimestamp those are the most recent n (mirroring "--tail N" semantics);
+	// otherwise they are the n with the highest slot indexes.
+	if o.limit > 0 && o.limit < len(results) {
+		results = results[len(results)-o.limit:]
+	}
+
+	return results, nil
+}
+
+func matches(entry Entry, o loadOptions) bool {
+	if !o.since.IsZero() && !entry.Timestamp.After(o.since) {
+		return false
+	}
+	if o.hasMaxAge && time.Since(entry.Timestamp) > o.maxAge {
+		return false
+	}
+	if o.category != "" && defaultCategorize(entry.Activity) != o.category {
+		return false
+	}
+	if o.activityRE != nil && !o.activityRE.MatchString(entry.Activity) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,149 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package activitytracker
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func writeTestSlot(t *testing.T, fd *os.File, ix int, ts time.Time, activity string) {
+	t.Helper()
+
+	buf := make([]byte, entrySize)
+	binary.BigEndian.PutUint64(buf[0:timestampLength], uint64(ts.UnixNano()))
+	binary.BigEndian.PutUint32(buf[timestampLength:timestampLength+totalLengthSize], uint32(len(activity)))
+	copy(buf[headerSize:], activity)
+
+	if _, err := fd.WriteAt(buf, int64(ix)*int64(entrySize)); err != nil {
+		t.Fatalf("writing test slot %d: %v", ix, err)
+	}
+}
+
+func newTestActivityFile(t *testing.T, activities []string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "activity.log")
+	fd, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("creating test activity file: %v", err)
+	}
+	defer func() { _ = fd.Close() }()
+
+	base := time.Now().Add(-time.Hour)
+	for ix, activity := range activities {
+		writeTestSlot(t, fd, ix, base.Add(time.Duration(ix)*time.Second), activity)
+	}
+
+	return path
+}
+
+func TestLoadUnfinishedEntries_WithCategory(t *testing.T) {
+	path := newTestActivityFile(t, []string{"query foo", "compact bar", "query baz"})
+
+	entries, err := LoadUnfinishedEntries(path, WithCategory("query"))
+	if err != nil {
+		t.Fatalf("LoadUnfinishedEntries: %v", err)
+	}
+
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d: %v", len(entries), entries)
+	}
+	for _, e := range entries {
+		if defaultCategorize(e.Activity) != "query" {
+			t.Fatalf("unexpected entry in results: %+v", e)
+		}
+	}
+}
+
+func TestLoadUnfinishedEntries_WithActivityRegex(t *testing.T) {
+	path := newTestActivityFile(t, []string{"query foo", "compact bar", "query baz"})
+
+	entries, err := LoadUnfinishedEntries(path, WithActivityRegex(regexp.MustCompile(`^query`)))
+	if err != nil {
+		t.Fatalf("LoadUnfinishedEntries: %v", err)
+	}
+
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d: %v", len(entries), entries)
+	}
+}
+
+func TestLoadUnfinishedEntries_WithSince(t *testing.T) {
+	path := newTestActivityFile(t, []string{"activity 0", "activity 1", "activity 2"})
+
+	entries, err := LoadUnfinishedEntries(path)
+	if err != nil {
+		t.Fatalf("LoadUnfinishedEntries: %v", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries, got %d: %v", len(entries), entries)
+	}
+
+	entries, err = LoadUnfinishedEntries(path, WithSince(entries[0].Timestamp))
+	if err != nil {
+		t.Fatalf("LoadUnfinishedEntries: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries after WithSince(entries[0].Timestamp), got %d: %v", len(entries), entries)
+	}
+	for _, e := range entries {
+		if e.Activity == "activity 0" {
+			t.Fatalf("WithSince should exclude the entry at the cutoff itself, got %+v", entries)
+		}
+	}
+}
+
+// TestLoadUnfinishedEntries_WithMaxAge guards the hasMaxAge zero-value guard in
+// loadOptions: WithMaxAge(0) must still filter down to entries younger than 0s
+// (i.e. none), which would silently become a no-op filter if hasMaxAge were
+// dropped and matches fell back to checking maxAge != 0.
+func TestLoadUnfinishedEntries_WithMaxAge(t *testing.T) {
+	path := newTestActivityFile(t, []string{"activity 0", "activity 1", "activity 2"})
+
+	entries, err := LoadUnfinishedEntries(path, WithMaxAge(30*time.Minute))
+	if err != nil {
+		t.Fatalf("LoadUnfinishedEntries: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("expected 0 entries (all about 1h old) to survive WithMaxAge(30m), got %d: %v", len(entries), entries)
+	}
+
+	entries, err = LoadUnfinishedEntries(path, WithMaxAge(2*time.Hour))
+	if err != nil {
+		t.Fatalf("LoadUnfinishedEntries: %v", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("expected all 3 entries to survive WithMaxAge(2h), got %d: %v", len(entries), entries)
+	}
+
+	entries, err = LoadUnfinishedEntries(path, WithMaxAge(0))
+	if err != nil {
+		t.Fatalf("LoadUnfinishedEntries: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("expected WithMaxAge(0) to exclude every entry (all are older than 0s), got %d: %v", len(entries), entries)
+	}
+}
+
+// TestLoadUnfinishedEntries_SortAndLimitReturnsMostRecent guards WithSort +
+// WithLimit's "--tail N" semantics: the most recent N entries, not the oldest N.
+func TestLoadUnfinishedEntries_SortAndLimitReturnsMostRecent(t *testing.T) {
+	path := newTestActivityFile(t, []string{"activity 0", "activity 1", "activity 2", "activity 3"})
+
+	entries, err := LoadUnfinishedEntries(path, WithSort(SortByTimestamp), WithLimit(2))
+	if err != nil {
+		t.Fatalf("LoadUnfinishedEntries: %v", err)
+	}
+
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d: %v", len(entries), entries)
+	}
+	if entries[0].Activity != "activity 2" || entries[1].Activity != "activity 3" {
+		t.Fatalf("expected the 2 most recent activities, got %+v", entries)
+	}
+}
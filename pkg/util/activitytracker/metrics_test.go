@@ -0,0 +1,216 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package activitytracker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// TestActivityTracker_DeleteObservesDurationUnderInsertTimeCategory guards against
+// Delete re-deriving the category from the activity string it decodes, instead of
+// using the category recorded at Insert time: Categorize returns a different value
+// on every call, so a re-derive at Delete would observe the duration under the
+// wrong label.
+func TestActivityTracker_DeleteObservesDurationUnderInsertTimeCategory(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+
+	calls := 0
+	cfg := Config{
+		Filepath:   t.TempDir() + "/activity.log",
+		MaxEntries: 8,
+		Categorize: func(string) string {
+			calls++
+			return [...]string{"insert-time", "delete-time"}[calls-1]
+		},
+	}
+	tracker, err := NewActivityTracker(cfg, reg)
+	if err != nil {
+		t.Fatalf("NewActivityTracker: %v", err)
+	}
+	defer func() { _ = tracker.Close() }()
+
+	ix := tracker.InsertStatic("doing some work")
+	if ix < 0 {
+		t.Fatalf("InsertStatic failed")
+	}
+	tracker.Delete(ix)
+
+	if calls != 1 {
+		t.Fatalf("expected Categorize to be called once (at Insert, not re-derived at Delete), got %d calls", calls)
+	}
+
+	count := testutil.CollectAndCount(tracker.activityDuration, "activity_tracker_duration_seconds")
+	if count != 1 {
+		t.Fatalf("expected a single activity_tracker_duration_seconds series, got %d", count)
+	}
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather: %v", err)
+	}
+	if category := durationSeriesCategory(t, mfs); category != "insert-time" {
+		t.Fatalf("duration observed under category %q, want the Insert-time category %q", category, "insert-time")
+	}
+}
+
+// durationSeriesCategory returns the "category" label of the single
+// activity_tracker_duration_seconds series present in mfs.
+func durationSeriesCategory(t *testing.T, mfs []*dto.MetricFamily) string {
+	t.Helper()
+
+	for _, mf := range mfs {
+		if mf.GetName() != "activity_tracker_duration_seconds" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "category" {
+					return l.GetValue()
+				}
+			}
+		}
+	}
+
+	t.Fatalf("no activity_tracker_duration_seconds series found")
+	return ""
+}
+
+func TestActivityTracker_OldestActivitySecondsEmptyTracker(t *testing.T) {
+	tracker := newTestTracker(t)
+
+	if got := tracker.oldestActivitySeconds(); got != 0 {
+		t.Fatalf("expected 0 on an empty tracker, got %v", got)
+	}
+}
+
+func TestActivityTracker_OldestActivitySecondsReturnsAgeOfOldest(t *testing.T) {
+	tracker := newTestTracker(t)
+
+	before := time.Now()
+	older := tracker.InsertStatic("older activity")
+	if older < 0 {
+		t.Fatalf("InsertStatic failed")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	newer := tracker.InsertStatic("newer activity")
+	if newer < 0 {
+		t.Fatalf("InsertStatic failed")
+	}
+
+	got := tracker.oldestActivitySeconds()
+	want := time.Since(before).Seconds()
+
+	// The oldest tracked entry is "older", not "newer", so oldestActivitySeconds
+	// should track its age (~nothing, plus the sleep), not the newer entry's.
+	if got <= 0 {
+		t.Fatalf("expected a positive age, got %v", got)
+	}
+	if got > want+1 {
+		t.Fatalf("oldestActivitySeconds = %v, should be roughly the age of the oldest entry (~%v)", got, want)
+	}
+
+	tracker.Delete(older)
+
+	gotAfterOldestDeleted := tracker.oldestActivitySeconds()
+	if gotAfterOldestDeleted <= 0 {
+		t.Fatalf("expected a positive age once the newer entry becomes the oldest remaining one, got %v", gotAfterOldestDeleted)
+	}
+	if gotAfterOldestDeleted >= got {
+		t.Fatalf("oldestActivitySeconds should have dropped once the older entry was deleted: before=%v after=%v", got, gotAfterOldestDeleted)
+	}
+}
+
+// TestActivityTracker_OldestActivitySecondsUnderConcurrentInsertDelete guards
+// against oldestActivitySeconds reading a torn timestamp from a slot that a
+// concurrent Delete is in the middle of zeroing: run with -race, which can only
+// catch this if the gauge and Insert/Delete touch the same synchronized state
+// (slotTimestamp), not the raw mmap buffer.
+func TestActivityTracker_OldestActivitySecondsUnderConcurrentInsertDelete(t *testing.T) {
+	tracker := newTestTracker(t)
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			ix := tracker.InsertStatic("churn")
+			if ix >= 0 {
+				tracker.Delete(ix)
+			}
+		}
+	}()
+
+	deadline := time.Now().Add(50 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		if got := tracker.oldestActivitySeconds(); got < 0 || got > 60 {
+			close(stop)
+			<-done
+			t.Fatalf("oldestActivitySeconds returned a nonsense age under concurrent Insert/Delete: %v", got)
+		}
+	}
+
+	close(stop)
+	<-done
+}
+
+func TestDefaultCategorize(t *testing.T) {
+	tests := []struct {
+		name     string
+		activity string
+		want     string
+	}{
+		{name: "no whitespace", activity: "query", want: "query"},
+		{name: "space delimited", activity: "query range from=0 to=100", want: "query"},
+		{name: "leading whitespace", activity: " query range", want: ""},
+		{name: "tab delimited", activity: "query\trange", want: "query"},
+		{name: "newline delimited", activity: "query\nrange", want: "query"},
+		{name: "empty", activity: "", want: ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := defaultCategorize(tc.activity); got != tc.want {
+				t.Fatalf("defaultCategorize(%q) = %q, want %q", tc.activity, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestActivityTracker_InsertWithCategoryBypassesConfigCategorize(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+
+	cfg := Config{
+		Filepath:   t.TempDir() + "/activity.log",
+		MaxEntries: 8,
+		Categorize: func(string) string { return "should_not_be_used" },
+	}
+	tracker, err := NewActivityTracker(cfg, reg)
+	if err != nil {
+		t.Fatalf("NewActivityTracker: %v", err)
+	}
+	defer func() { _ = tracker.Close() }()
+
+	ix := tracker.InsertWithCategory("explicit", func() string { return "some activity" })
+	if ix < 0 {
+		t.Fatalf("InsertWithCategory failed")
+	}
+
+	if got := testutil.ToFloat64(tracker.activitiesTotal.WithLabelValues("explicit")); got != 1 {
+		t.Fatalf("activity_tracker_activities_total{category=\"explicit\"} = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(tracker.activitiesTotal.WithLabelValues("should_not_be_used")); got != 0 {
+		t.Fatalf("InsertWithCategory must not also record under Config.Categorize's category, got %v", got)
+	}
+}
@@ -0,0 +1,287 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package activitytracker
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/grafana/dskit/multierror"
+	"github.com/pkg/errors"
+)
+
+// overflowRef locates the tail of an oversized activity in an overflow segment.
+// A zero-value overflowRef (length 0) means the activity had no overflow tail.
+type overflowRef struct {
+	segment uint32
+	offset  int64
+	length  int
+}
+
+// overflowSegmentPath returns the path of overflow segment seg for an activity
+// tracker whose main file is at basePath, following the same numbered-suffix
+// convention as Prometheus WAL segments.
+func overflowSegmentPath(basePath string, seg uint32) string {
+	return fmt.Sprintf("%s.overflow.%08d", basePath, seg)
+}
+
+// sizeClass rounds n up to the nearest power-of-two bucket (minimum 64), used to
+// match overflow writes against the in-memory freelist without requiring an exact
+// length match.
+func sizeClass(n int) int {
+	class := 64
+	for class < n {
+		class *= 2
+	}
+	return class
+}
+
+// overflowStore is the append-only, segmented companion file that holds the parts
+// of activities too large to fit inline in the fixed-slot file. Writing reuses
+// freed ranges of any still-open segment where possible, and only appends to the
+// active segment once no freed range of sufficient size is available.
+type overflowStore struct {
+	basePath       string
+	thresholdBytes int64
+
+	mu            sync.Mutex
+	activeSegment uint32
+	activeFile    *os.File
+	writeOffset   int64
+
+	segmentFiles map[uint32]*os.File
+	liveRefs     map[uint32]int
+	freelist     map[int][]overflowRef
+}
+
+// newOverflowStore creates (or recreates) the overflow store for an activity
+// tracker file at basePath. Any overflow segments left over from a previous run
+// are removed, mirroring the "recreated from scratch" semantics of the main file.
+func newOverflowStore(basePath string, thresholdBytes int64) (*overflowStore, error) {
+	stale, err := filepath.Glob(basePath + ".overflow.*")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list stale activity overflow segments")
+	}
+	for _, f := range stale {
+		_ = os.Remove(f)
+	}
+
+	s := &overflowStore{
+		basePath:       basePath,
+		thresholdBytes: thresholdBytes,
+		segmentFiles:   map[uint32]*os.File{},
+		liveRefs:       map[uint32]int{},
+		freelist:       map[int][]overflowRef{},
+	}
+
+	if err := s.openSegment(0); err != nil {
+		return nil, err
+	}
+	s.activeFile = s.segmentFiles[0]
+
+	return s, nil
+}
+
+func (s *overflowStore) openSegment(seg uint32) error {
+	file, err := os.OpenFile(overflowSegmentPath(s.basePath, seg), os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0666)
+	if err != nil {
+		return errors.Wrap(err, "failed to create activity overflow segment")
+	}
+	s.segmentFiles[seg] = file
+	return nil
+}
+
+// put writes tail to the overflow store, reusing a freed range if one of
+// sufficient size is available, and returns the reference needed to read it back.
+func (s *overflowStore) put(tail []byte) (overflowRef, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	need := len(tail)
+	class := sizeClass(need)
+	if free := s.freelist[class]; len(free) > 0 {
+		for i, candidate := range free {
+			// A freed range only guarantees its own (un-padded) length, which can be
+			// smaller than need even within the same size class: sizeClass buckets by
+			// "rounds up to", not "rounds up from", so e.g. both a 40-byte and a 60-byte
+			// range land in the 64-byte class. Reusing a too-small range would overwrite
+			// whatever was appended right after it.
+			if candidate.length < need {
+				continue
+			}
+
+			ref := candidate
+			ref.length = need
+			s.freelist[class] = append(free[:i], free[i+1:]...)
+
+			if _, err := s.segmentFiles[ref.segment].WriteAt(tail, ref.offset); err != nil {
+				return overflowRef{}, errors.Wrap(err, "failed to write activity overflow")
+			}
+			s.liveRefs[ref.segment]++
+			return ref, nil
+		}
+	}
+
+	if s.writeOffset+int64(len(tail)) > s.thresholdBytes {
+		if err := s.rotate(); err != nil {
+			return overflowRef{}, err
+		}
+	}
+
+	ref := overflowRef{segment: s.activeSegment, offset: s.writeOffset, length: len(tail)}
+	if _, err := s.activeFile.WriteAt(tail, ref.offset); err != nil {
+		return overflowRef{}, errors.Wrap(err, "failed to write activity overflow")
+	}
+	s.writeOffset += int64(len(tail))
+	s.liveRefs[ref.segment]++
+
+	return ref, nil
+}
+
+// rotate starts a new, empty active segment. The previous segment is left open
+// for as long as it still has live references.
+func (s *overflowStore) rotate() error {
+	s.activeSegment++
+	if err := s.openSegment(s.activeSegment); err != nil {
+		return err
+	}
+	s.activeFile = s.segmentFiles[s.activeSegment]
+	s.writeOffset = 0
+	return nil
+}
+
+// get reads back the bytes previously written for ref.
+func (s *overflowStore) get(ref overflowRef) ([]byte, error) {
+	if ref.length == 0 {
+		return nil, nil
+	}
+
+	s.mu.Lock()
+	file, ok := s.segmentFiles[ref.segment]
+	s.mu.Unlock()
+	if !ok {
+		return nil, errors.Errorf("activity overflow segment %d is not open", ref.segment)
+	}
+
+	return readOverflowAt(file, ref)
+}
+
+// readOverflowAt reads the bytes described by ref out of file. ref is decoded from the
+// slot header of an activity file that may belong to a separate, live process (e.g.
+// FollowFile or LoadUnfinishedEntries tailing another ingester's shared volume), so a
+// slot caught mid-write can carry a garbage length; bounds-check against the file's
+// actual size before allocating, rather than trusting ref.length outright.
+func readOverflowAt(file *os.File, ref overflowRef) ([]byte, error) {
+	if ref.offset < 0 || ref.length < 0 {
+		return nil, errors.Errorf("invalid activity overflow reference: %+v", ref)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to stat activity overflow segment")
+	}
+	if ref.offset > info.Size() || int64(ref.length) > info.Size()-ref.offset {
+		return nil, errors.Errorf("activity overflow reference %+v exceeds segment size %d, likely a torn read", ref, info.Size())
+	}
+
+	buf := make([]byte, ref.length)
+	if _, err := file.ReadAt(buf, ref.offset); err != nil {
+		return nil, errors.Wrap(err, "failed to read activity overflow")
+	}
+	return buf, nil
+}
+
+// release marks ref's range reclaimable, and unlinks its segment once that
+// segment holds no more live references (and is no longer the active segment).
+func (s *overflowStore) release(ref overflowRef) {
+	if ref.length == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	class := sizeClass(ref.length)
+	s.freelist[class] = append(s.freelist[class], ref)
+
+	s.liveRefs[ref.segment]--
+	if s.liveRefs[ref.segment] > 0 || ref.segment == s.activeSegment {
+		return
+	}
+
+	if file, ok := s.segmentFiles[ref.segment]; ok {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+		delete(s.segmentFiles, ref.segment)
+	}
+	delete(s.liveRefs, ref.segment)
+
+	for c, refs := range s.freelist {
+		kept := refs[:0]
+		for _, r := range refs {
+			if r.segment != ref.segment {
+				kept = append(kept, r)
+			}
+		}
+		s.freelist[c] = kept
+	}
+}
+
+func (s *overflowStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	errs := make([]error, 0, len(s.segmentFiles))
+	for _, file := range s.segmentFiles {
+		errs = append(errs, file.Close())
+	}
+	return multierror.New(errs...).Err()
+}
+
+// overflowReader provides read-only, lazy access to overflow segments, for use by
+// tooling (LoadUnfinishedEntries, FollowFile) that reads an activity file written
+// by another process and only needs to stitch back the tails it is told about.
+type overflowReader struct {
+	basePath string
+
+	mu    sync.Mutex
+	files map[uint32]*os.File
+}
+
+func newOverflowReader(basePath string) *overflowReader {
+	return &overflowReader{basePath: basePath, files: map[uint32]*os.File{}}
+}
+
+func (r *overflowReader) get(ref overflowRef) ([]byte, error) {
+	if ref.length == 0 {
+		return nil, nil
+	}
+
+	r.mu.Lock()
+	file, ok := r.files[ref.segment]
+	if !ok {
+		var err error
+		file, err = os.Open(overflowSegmentPath(r.basePath, ref.segment))
+		if err != nil {
+			r.mu.Unlock()
+			return nil, errors.Wrap(err, "failed to open activity overflow segment")
+		}
+		r.files[ref.segment] = file
+	}
+	r.mu.Unlock()
+
+	return readOverflowAt(file, ref)
+}
+
+func (r *overflowReader) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	errs := make([]error, 0, len(r.files))
+	for _, file := range r.files {
+		errs = append(errs, file.Close())
+	}
+	return multierror.New(errs...).Err()
+}
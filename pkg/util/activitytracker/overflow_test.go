@@ -0,0 +1,187 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package activitytracker
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOverflowStore_PutGetRoundTrip(t *testing.T) {
+	store, err := newOverflowStore(filepath.Join(t.TempDir(), "activity.log"), 1<<20)
+	if err != nil {
+		t.Fatalf("newOverflowStore: %v", err)
+	}
+	defer func() { _ = store.Close() }()
+
+	want := []byte("some overflowed activity tail")
+	ref, err := store.put(want)
+	if err != nil {
+		t.Fatalf("put: %v", err)
+	}
+
+	got, err := store.get(ref)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+// TestOverflowStore_ReusedRangeDoesNotCorruptNeighbour guards against reusing a
+// freed range that is smaller than the new write: both a freed 40-byte range and a
+// new 60-byte write round up to the same size class, so a reuse that only checks
+// the class (instead of the range's actual length) would overwrite the start of
+// whatever was appended right after it.
+func TestOverflowStore_ReusedRangeDoesNotCorruptNeighbour(t *testing.T) {
+	store, err := newOverflowStore(filepath.Join(t.TempDir(), "activity.log"), 1<<20)
+	if err != nil {
+		t.Fatalf("newOverflowStore: %v", err)
+	}
+	defer func() { _ = store.Close() }()
+
+	a := bytes.Repeat([]byte("A"), 40)
+	b := bytes.Repeat([]byte("B"), 40)
+
+	refA, err := store.put(a)
+	if err != nil {
+		t.Fatalf("put a: %v", err)
+	}
+	refB, err := store.put(b)
+	if err != nil {
+		t.Fatalf("put b: %v", err)
+	}
+
+	store.release(refA)
+
+	c := bytes.Repeat([]byte("C"), 60)
+	if _, err := store.put(c); err != nil {
+		t.Fatalf("put c: %v", err)
+	}
+
+	got, err := store.get(refB)
+	if err != nil {
+		t.Fatalf("get b: %v", err)
+	}
+	if !bytes.Equal(got, b) {
+		t.Fatalf("activity b corrupted by reused freelist range: got %q, want %q", got, b)
+	}
+}
+
+func TestOverflowStore_ReleaseUnlinksDeadSegment(t *testing.T) {
+	base := filepath.Join(t.TempDir(), "activity.log")
+
+	first := []byte("first activity tail")
+	// A threshold equal to len(first) lets the first write land in (and exactly fill)
+	// segment 0, so the second write is the one that forces a rotation.
+	store, err := newOverflowStore(base, int64(len(first)))
+	if err != nil {
+		t.Fatalf("newOverflowStore: %v", err)
+	}
+	defer func() { _ = store.Close() }()
+
+	ref, err := store.put(first)
+	if err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	if ref.segment != 0 {
+		t.Fatalf("expected first write in segment 0, got %d", ref.segment)
+	}
+
+	if _, err := store.put([]byte("second activity tail")); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	if store.activeSegment == 0 {
+		t.Fatalf("expected a rotation to a new segment")
+	}
+
+	store.release(ref)
+
+	if _, ok := store.segmentFiles[0]; ok {
+		t.Fatalf("segment 0 should have been unlinked once its only reference was released")
+	}
+}
+
+// TestActivityTracker_LargeActivityOverflowRoundTrip guards the Insert -> overflow
+// write -> Delete/LoadUnfinishedEntries/Follow round trip for an activity longer
+// than inlineActivitySize: the overflowStore tests above only drive put/get
+// directly, and every other tracker test inserts short strings, so none of them
+// would catch a field-width or offset mistake in insert()'s header encoding or in
+// decodeSlotHeader, even though overflowStore's own unit tests would keep passing.
+func TestActivityTracker_LargeActivityOverflowRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "activity.log")
+
+	cfg := Config{Filepath: path, MaxEntries: 8}
+	tracker, err := NewActivityTracker(cfg, nil)
+	if err != nil {
+		t.Fatalf("NewActivityTracker: %v", err)
+	}
+	defer func() { _ = tracker.Close() }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events, err := tracker.Follow(ctx, FollowOptions{})
+	if err != nil {
+		t.Fatalf("Follow: %v", err)
+	}
+
+	large := strings.Repeat("x", inlineActivitySize+500)
+
+	ix := tracker.InsertStatic(large)
+	if ix < 0 {
+		t.Fatalf("InsertStatic failed")
+	}
+
+	inserted := waitForEvent(t, events)
+	if inserted.Kind != Inserted || inserted.Entry.Activity != large {
+		t.Fatalf("Follow did not reconstruct the full overflowed activity: got %d bytes, want %d", len(inserted.Entry.Activity), len(large))
+	}
+
+	entries, err := LoadUnfinishedEntries(path)
+	if err != nil {
+		t.Fatalf("LoadUnfinishedEntries: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Activity != large {
+		t.Fatalf("LoadUnfinishedEntries did not reconstruct the full overflowed activity, got %d entries", len(entries))
+	}
+
+	tracker.Delete(ix)
+
+	deleted := waitForEvent(t, events)
+	if deleted.Kind != Deleted || deleted.Entry.Activity != large {
+		t.Fatalf("Deleted event did not carry the full overflowed activity: got %d bytes, want %d", len(deleted.Entry.Activity), len(large))
+	}
+
+	if live := tracker.overflow.liveRefs[0]; live != 0 {
+		t.Fatalf("expected Delete to release the overflow range, liveRefs[0] = %d", live)
+	}
+}
+
+// TestOverflowStore_GetRejectsOutOfBoundsLength guards against a torn read of a slot
+// header (possible when reading the file of a separate, live process) turning a
+// garbage length into a multi-gigabyte allocation: get must bounds-check ref.length
+// against the segment's actual size instead of trusting it outright.
+func TestOverflowStore_GetRejectsOutOfBoundsLength(t *testing.T) {
+	store, err := newOverflowStore(filepath.Join(t.TempDir(), "activity.log"), 1<<20)
+	if err != nil {
+		t.Fatalf("newOverflowStore: %v", err)
+	}
+	defer func() { _ = store.Close() }()
+
+	ref, err := store.put([]byte("short tail"))
+	if err != nil {
+		t.Fatalf("put: %v", err)
+	}
+
+	corrupt := ref
+	corrupt.length = 1 << 32
+
+	if _, err := store.get(corrupt); err == nil {
+		t.Fatalf("expected an error for an out-of-bounds overflow length, got nil")
+	}
+}